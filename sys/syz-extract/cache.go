@@ -0,0 +1,151 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// extractorVersion is bumped whenever the generated probe programs or the
+// extraction logic changes in a way that can affect results, so that stale
+// cache entries from an older binary are not reused silently.
+const extractorVersion = "1"
+
+// cache is a content-addressed, on-disk store of previously extracted
+// constant values. It is keyed on everything that can affect the result of
+// extracting a single constant: OS, arch, input file, constant name, and a
+// hash of the include dirs/kernel headers/extractor version that were used
+// to produce it. 缓存的目的是跳过未发生变化的常量的重复编译提取.
+type cache struct {
+	dir      string
+	disabled bool
+	refresh  bool
+}
+
+func newCache(dir string, disabled, refresh bool) *cache {
+	return &cache{dir: dir, disabled: disabled, refresh: refresh}
+}
+
+func defaultCacheDir(OS string) string {
+	return filepath.Join("sys", OS, ".const-cache")
+}
+
+type cacheEntry struct {
+	Value      uint64
+	Undeclared bool
+}
+
+func (c *cache) key(OS, arch, file, constName, headerHash string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v|%v|%v|%v|%v|%v", OS, arch, file, constName, headerHash, extractorVersion)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+func (c *cache) lookup(key string) (cacheEntry, bool) {
+	if c.disabled || c.refresh {
+		return cacheEntry{}, false
+	}
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	line := strings.TrimSpace(string(data))
+	if line == "undeclared" {
+		return cacheEntry{Undeclared: true}, true
+	}
+	val, err := strconv.ParseUint(line, 10, 64)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	return cacheEntry{Value: val}, true
+}
+
+// store writes the entry for key atomically (write to a temp file in the
+// same cache dir, then rename) so that concurrent workers never observe a
+// partially written cache file.
+func (c *cache) store(key string, entry cacheEntry) error {
+	if c.disabled {
+		return nil
+	}
+	dir := filepath.Join(c.dir, key[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data := strconv.FormatUint(entry.Value, 10)
+	if entry.Undeclared {
+		data = "undeclared"
+	}
+	tmp, err := ioutil.TempFile(dir, key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// headerHash computes a fast, deterministic fingerprint of everything that
+// can change the meaning of the const probe for this arch: the kernel
+// headers that will be pulled in and the extra -includedirs. Hashing file
+// metadata (path+size+mtime) rather than full contents keeps this cheap even
+// for a full kernel source tree, while still invalidating the cache whenever
+// a header is touched.
+func headerHash(arch *Arch) (string, error) {
+	h := sha256.New()
+	var dirs []string
+	dirs = append(dirs, strings.Split(arch.includeDirs, ",")...)
+	if arch.sourceDir != "" {
+		dirs = append(dirs, arch.sourceDir)
+	}
+	// arch.buildDir is intentionally not hashed: for the default -build flow
+	// (no -builddir given) it's a freshly allocated ioutil.TempDir on every
+	// run, so its path and the mtimes of the headers `make prepare` writes
+	// into it are never stable across invocations. Its generated headers are
+	// a deterministic function of arch.sourceDir (already hashed above) and
+	// the target arch (already part of the cache key), so nothing is lost.
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if err := hashDir(h, dir); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashDir(h interface{ Write([]byte) (int, error) }, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Missing/inaccessible dirs (e.g. arch-specific headers not
+			// generated yet) shouldn't fail the whole hash.
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		fmt.Fprintf(h, "%v:%v:%v\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+}
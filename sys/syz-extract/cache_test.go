@@ -0,0 +1,150 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKeyStable(t *testing.T) {
+	c := newCache(t.TempDir(), false, false)
+	k1 := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	k2 := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if k1 != k2 {
+		t.Fatalf("key() is not deterministic: %v != %v", k1, k2)
+	}
+	if k3 := c.key("linux", "amd64", "sys/linux/sys.txt", "BAR", "somehash"); k3 == k1 {
+		t.Fatalf("key() collided for different const names: %v", k3)
+	}
+}
+
+func TestCacheStoreLookup(t *testing.T) {
+	c := newCache(t.TempDir(), false, false)
+	key := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if _, ok := c.lookup(key); ok {
+		t.Fatalf("lookup() found an entry before store()")
+	}
+	if err := c.store(key, cacheEntry{Value: 42}); err != nil {
+		t.Fatalf("store() failed: %v", err)
+	}
+	entry, ok := c.lookup(key)
+	if !ok {
+		t.Fatalf("lookup() did not find the stored entry")
+	}
+	if entry.Value != 42 || entry.Undeclared {
+		t.Fatalf("lookup() returned %+v, want Value: 42", entry)
+	}
+}
+
+func TestCacheStoreUndeclared(t *testing.T) {
+	c := newCache(t.TempDir(), false, false)
+	key := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if err := c.store(key, cacheEntry{Undeclared: true}); err != nil {
+		t.Fatalf("store() failed: %v", err)
+	}
+	entry, ok := c.lookup(key)
+	if !ok || !entry.Undeclared {
+		t.Fatalf("lookup() returned %+v, want Undeclared: true", entry)
+	}
+}
+
+func TestCacheStoreNoPartialFile(t *testing.T) {
+	// store() must never leave a file at the final path other than one it
+	// finished writing completely: it writes to a temp file and renames,
+	// so a lookup can only ever see a complete entry.
+	c := newCache(t.TempDir(), false, false)
+	key := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if err := c.store(key, cacheEntry{Value: 1}); err != nil {
+		t.Fatalf("store() failed: %v", err)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(c.dir, key[:2]))
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("cache dir has %v entries, want 1 (no leftover temp files): %v", len(entries), entries)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	c := newCache(t.TempDir(), true, false)
+	key := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if err := c.store(key, cacheEntry{Value: 1}); err != nil {
+		t.Fatalf("store() failed: %v", err)
+	}
+	if _, ok := c.lookup(key); ok {
+		t.Fatalf("lookup() found an entry while cache is disabled")
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	c := newCache(t.TempDir(), false, false)
+	key := c.key("linux", "amd64", "sys/linux/sys.txt", "FOO", "somehash")
+	if err := c.store(key, cacheEntry{Value: 1}); err != nil {
+		t.Fatalf("store() failed: %v", err)
+	}
+	refreshing := newCache(c.dir, false, true)
+	if _, ok := refreshing.lookup(key); ok {
+		t.Fatalf("lookup() found an entry while refresh is requested")
+	}
+}
+
+func TestHeaderHashExcludesBuildDir(t *testing.T) {
+	sourceDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(sourceDir, "unistd.h"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	buildDir1, buildDir2 := t.TempDir(), t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(buildDir1, "generated.h"), []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(buildDir2, "generated.h"), []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch1 := &Arch{sourceDir: sourceDir, buildDir: buildDir1}
+	arch2 := &Arch{sourceDir: sourceDir, buildDir: buildDir2}
+	hash1, err := headerHash(arch1)
+	if err != nil {
+		t.Fatalf("headerHash() failed: %v", err)
+	}
+	hash2, err := headerHash(arch2)
+	if err != nil {
+		t.Fatalf("headerHash() failed: %v", err)
+	}
+	if hash1 != hash2 {
+		t.Fatalf("headerHash() differs for arches that only differ in buildDir path: %v != %v", hash1, hash2)
+	}
+}
+
+func TestHeaderHashChangesWithSourceDir(t *testing.T) {
+	sourceDir1 := t.TempDir()
+	sourceDir2 := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(sourceDir2, "unistd.h"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch1 := &Arch{sourceDir: sourceDir1}
+	arch2 := &Arch{sourceDir: sourceDir2}
+	hash1, err := headerHash(arch1)
+	if err != nil {
+		t.Fatalf("headerHash() failed: %v", err)
+	}
+	hash2, err := headerHash(arch2)
+	if err != nil {
+		t.Fatalf("headerHash() failed: %v", err)
+	}
+	if hash1 == hash2 {
+		t.Fatalf("headerHash() did not change when sourceDir contents changed")
+	}
+}
+
+func TestHashDirMissingDirIsNotError(t *testing.T) {
+	h := sha256.New()
+	if err := hashDir(h, filepath.Join(os.TempDir(), "syz-extract-does-not-exist")); err != nil {
+		t.Fatalf("hashDir() failed for a missing dir: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/google/syzkaller/pkg/compiler"
+	"github.com/google/syzkaller/pkg/osutil"
+	"github.com/google/syzkaller/sys/targets"
+)
+
+// ccompiler compiles and runs small probe programs with the cross toolchain
+// for the target arch, extracting the emitted constant values from stdout.
+// It is shared by the Linux/BSD/Darwin extractors, which only differ in the
+// headers they need to pull in and in how the resulting binary is executed.
+type ccompiler struct {
+	target     *targets.Target
+	buildDir   string
+	includeDir string
+}
+
+func (cc *ccompiler) compile(ctx context.Context, probe, out string, cflags []string) error {
+	args := []string{
+		"-x", "c", "-o", out, probe,
+		"-w",
+	}
+	args = append(args, cc.target.CrossCFlags...)
+	for _, dir := range strings.Split(cc.includeDir, ",") {
+		if dir == "" {
+			continue
+		}
+		args = append(args, "-I"+dir)
+	}
+	if cc.buildDir != "" {
+		args = append(args, "-I"+filepath.Join(cc.buildDir, "include"))
+	}
+	args = append(args, cflags...)
+	compiler := cc.target.CCompilerPrefix + "gcc"
+	cmd := exec.CommandContext(ctx, compiler, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to compile probe: %v\n%s", err, out)
+	}
+	return nil
+}
+
+// run executes the compiled probe, using qemu-user emulation when the host
+// can't natively run binaries for the target arch.
+func (cc *ccompiler) run(ctx context.Context, bin string) (string, error) {
+	if cc.target.Arch == targets.Host {
+		out, err := exec.CommandContext(ctx, bin).CombinedOutput()
+		if err != nil {
+			return "", fmt.Errorf("failed to run probe: %v\n%s", err, out)
+		}
+		return string(out), nil
+	}
+	qemu := "qemu-" + cc.target.Qemu + "-static"
+	out, err := exec.CommandContext(ctx, qemu, bin).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run probe under %v: %v\n%s", qemu, err, out)
+	}
+	return string(out), nil
+}
+
+func (cc *ccompiler) compileAndRun(ctx context.Context, probe string, cflags []string) (string, error) {
+	bin, err := osutil.TempFile("syz-extract-bin")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(bin)
+	if err := cc.compile(ctx, probe, bin, cflags); err != nil {
+		return "", err
+	}
+	return cc.run(ctx, bin)
+}
+
+// probeTmpl is a small C program that prints one line per requested
+// constant, either its numeric value or "undeclared" if no header defined it.
+var probeTmpl = template.Must(template.New("probe").Parse(`
+#include <stdio.h>
+{{range $incl := .Includes}}#include <{{$incl}}>
+{{end}}
+
+int main() {
+{{range $name := .Consts}}
+#ifdef {{$name}}
+	printf("{{$name}} %llu\n", (unsigned long long)({{$name}}));
+#else
+	printf("{{$name}} undeclared\n");
+#endif
+{{end}}
+	return 0;
+}
+`))
+
+func genProbe(info *compiler.ConstInfo) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := probeTmpl.Execute(buf, struct {
+		Includes []string
+		Consts   []string
+	}{
+		Includes: info.Includes,
+		Consts:   info.Consts,
+	}); err != nil {
+		return "", err
+	}
+	name, err := osutil.TempFile("syz-extract-probe.c")
+	if err != nil {
+		return "", err
+	}
+	if err := osutil.WriteFile(name, buf.Bytes()); err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
+func parseProbeOutput(out string) (map[string]uint64, map[string]bool, error) {
+	res := make(map[string]uint64)
+	undeclared := make(map[string]bool)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name, val := fields[0], fields[1]
+		if val == "undeclared" {
+			undeclared[name] = true
+			continue
+		}
+		v, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse value for %v: %v", name, val)
+		}
+		res[name] = v
+	}
+	return res, undeclared, nil
+}
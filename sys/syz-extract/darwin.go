@@ -0,0 +1,45 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/google/syzkaller/pkg/compiler"
+)
+
+type darwin struct{}
+
+func (*darwin) prepare(sourcedir string, build bool, arches []*Arch) error {
+	if sourcedir == "" {
+		return fmt.Errorf("provide path to kernel checkout via -sourcedir flag (or make extract SOURCEDIR)")
+	}
+	if build {
+		return fmt.Errorf("-build is not supported for darwin")
+	}
+	return nil
+}
+
+func (*darwin) prepareArch(arch *Arch) error {
+	return nil
+}
+
+func (*darwin) processFile(ctx context.Context, arch *Arch, info *compiler.ConstInfo) (map[string]uint64, map[string]bool, error) {
+	cc := &ccompiler{
+		target:     arch.target,
+		includeDir: arch.includeDirs,
+	}
+	probe, err := genProbe(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(probe)
+	out, err := cc.compileAndRun(ctx, probe, []string{"-I", arch.sourceDir})
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseProbeOutput(out)
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/syzkaller/pkg/ast"
 	"github.com/google/syzkaller/pkg/compiler"
@@ -28,6 +30,14 @@ var (
 	flagIncludes  = flag.String("includedirs", "", "path to other kernel source include dirs separated by commas")
 	flagBuildDir  = flag.String("builddir", "", "path to kernel build dir")
 	flagArch      = flag.String("arch", "", "comma-separated list of arches to generate (all by default)")
+	flagCacheDir  = flag.String("cachedir", "", "path to const extraction cache dir (default sys/<os>/.const-cache)")
+	flagNoCache   = flag.Bool("nocache", false, "disable the on-disk extraction cache")
+	flagRefresh   = flag.Bool("refresh", false, "ignore cached results and re-extract everything")
+	flagJSON      = flag.Bool("json", false, "emit newline-delimited JSON progress/error events instead of text")
+	flagJobs      = flag.Int("jobs", runtime.GOMAXPROCS(0), "number of concurrent extraction jobs")
+	flagMemLimit  = flag.Int64("memlimit", 0, "total memory budget in bytes for in-flight jobs (0 = unlimited)")
+	flagTimeout   = flag.Duration("timeout", 0, "per-file extraction timeout, e.g. 5m (0 = no timeout)")
+	flagPlan      = flag.Bool("plan", false, "print the extraction plan (consts, includes, cache status) and exit without compiling anything")
 )
 
 type Arch struct {
@@ -39,6 +49,7 @@ type Arch struct {
 	files       []*File
 	err         error
 	done        chan bool
+	hdrHash     string // fingerprint of headers/includedirs, used as a cache key component
 }
 
 type File struct {
@@ -54,19 +65,29 @@ type File struct {
 type Extractor interface {
 	prepare(sourcedir string, build bool, arches []*Arch) error
 	prepareArch(arch *Arch) error
-	processFile(arch *Arch, info *compiler.ConstInfo) (map[string]uint64, map[string]bool, error)
+	processFile(ctx context.Context, arch *Arch, info *compiler.ConstInfo) (map[string]uint64, map[string]bool, error)
 }
 
 var extractors = map[string]Extractor{
 	targets.Windows: new(windows),
+	targets.Linux:   new(linux),
+	targets.FreeBSD: new(freebsd),
+	targets.NetBSD:  new(netbsd),
+	targets.OpenBSD: new(openbsd),
+	targets.Darwin:  new(darwin),
 }
 
+var extractCache *cache
+
 func main() {
 	// 解析参数，主要是OS、arch、syzlang文件名
 	flag.Parse()
 	if *flagBuild && *flagBuildDir != "" {
 		tool.Failf("-build and -builddir is an invalid combination")
 	}
+	if *flagJobs <= 0 {
+		tool.Failf("-jobs must be positive, got %v", *flagJobs)
+	}
 	OS := *flagOS
 	extractor := extractors[OS]
 	if extractor == nil {
@@ -81,17 +102,40 @@ func main() {
 		tool.Fail(fmt.Errorf("provide path to kernel checkout via -sourcedir " +
 			"flag (or make extract SOURCEDIR)"))
 	}
+	cacheDir := *flagCacheDir
+	if cacheDir == "" {
+		cacheDir = defaultCacheDir(OS)
+	}
+	extractCache = newCache(cacheDir, *flagNoCache, *flagRefresh)
+
+	if *flagPlan {
+		if runPlan(OS, arches) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := extractor.prepare(*flagSourceDir, *flagBuild, arches); err != nil {
 		tool.Fail(err)
 	}
 
-	jobC := make(chan interface{}, len(arches))
+	// jobC must be able to hold every *Arch job plus every *File job each of
+	// them can push once it's done, since a worker pushes its arch's *File
+	// jobs onto jobC from inside the same loop that drains it: with -jobs=1
+	// (or just fewer workers than arches) a buffer sized only to len(arches)
+	// fills up and that worker deadlocks sending to a channel only it reads.
+	totalJobs := len(arches)
+	for _, arch := range arches {
+		totalJobs += len(arch.files)
+	}
+	jobC := make(chan interface{}, totalJobs)
 	for _, arch := range arches {
 		jobC <- arch
 	}
-	// 对每种arch架构，多线程并发执行worker
-	for p := 0; p < runtime.GOMAXPROCS(0); p++ {
-		go worker(extractor, jobC)
+	mem := newMemSem(*flagMemLimit)
+	// 对每种arch架构，多线程并发执行worker，worker数量由-jobs控制
+	for p := 0; p < *flagJobs; p++ {
+		go worker(extractor, jobC, mem)
 	}
 
 	failed := false
@@ -99,19 +143,25 @@ func main() {
 	// 这里采用了管道进行线程同步，worker函数中执行close操作后
 	// 相应的管道将不再等待
 	for _, arch := range arches {
-		fmt.Printf("generating %v/%v...\n", OS, arch.target.Arch)
+		if !*flagJSON {
+			fmt.Printf("generating %v/%v...\n", OS, arch.target.Arch)
+		}
 		// 这个语句会阻塞等待管道
 		<-arch.done
 		if arch.err != nil {
 			failed = true
-			fmt.Printf("%v\n", arch.err)
+			if !*flagJSON {
+				fmt.Printf("%v\n", arch.err)
+			}
 			continue
 		}
 		for _, f := range arch.files {
 			<-f.done
 			if f.err != nil {
 				failed = true
-				fmt.Printf("%v: %v\n", f.name, f.err)
+				if !*flagJSON {
+					fmt.Printf("%v: %v\n", f.name, f.err)
+				}
 				continue
 			}
 			if constFiles[f.name] == nil {
@@ -146,12 +196,16 @@ func main() {
 	}
 }
 
-func worker(extractor Extractor, jobC chan interface{}) {
+func worker(extractor Extractor, jobC chan interface{}, mem *memSem) {
 	for job := range jobC {
 		switch j := job.(type) {
 		case *Arch:
+			// 在开始处理前按预估内存占用申请配额，超出-memlimit时会阻塞在此处
+			budget := archMemBudget(j)
+			mem.acquire(budget)
 			// 处理传入的extractor和arch结构体
 			infos, err := processArch(extractor, j)
+			mem.release(budget)
 			j.err = err
 			// 将管道关闭是为了通知main()函数go routine 某部分工作已经完成
 			// 类似于使用信号量来保证线程同步
@@ -163,8 +217,18 @@ func worker(extractor Extractor, jobC chan interface{}) {
 				}
 			}
 		case *File:
+			mem.acquire(fileMemBudget)
+			ctx := context.Background()
+			var cancel context.CancelFunc
+			if *flagTimeout != 0 {
+				ctx, cancel = context.WithTimeout(ctx, *flagTimeout)
+			}
 			// 编译生成可执行文件，并搜集常量
-			j.consts, j.undeclared, j.err = processFile(extractor, j.arch, j)
+			j.consts, j.undeclared, j.err = processFile(ctx, extractor, j.arch, j)
+			if cancel != nil {
+				cancel()
+			}
+			mem.release(fileMemBudget)
 			close(j.done)
 		}
 	}
@@ -266,36 +330,86 @@ func checkUnsupportedCalls(arches []*Arch) bool {
 			continue
 		}
 		failed = true
-		fmt.Printf("%v: %v is unsupported on all arches (typo?)\n",
-			file, name)
+		msg := fmt.Sprintf("%v is unsupported on all arches (typo?)", name)
+		if *flagJSON {
+			emitEvent(event{Event: "error", OS: arches[0].target.OS, File: file, Err: msg})
+		} else {
+			fmt.Printf("%v: %v\n", file, msg)
+		}
 	}
 	return failed
 }
 
 func processArch(extractor Extractor, arch *Arch) (map[string]*compiler.ConstInfo, error) {
+	start := time.Now()
+	emitEvent(event{Event: "start", OS: arch.target.OS, Arch: arch.target.Arch})
+	infos, err := processArchInner(extractor, arch)
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		emitEvent(event{Event: "error", OS: arch.target.OS, Arch: arch.target.Arch, ElapsedMS: elapsed, Err: err.Error()})
+	} else {
+		emitEvent(event{Event: "done", OS: arch.target.OS, Arch: arch.target.Arch, ElapsedMS: elapsed})
+	}
+	return infos, err
+}
+
+func processArchInner(extractor Extractor, arch *Arch) (map[string]*compiler.ConstInfo, error) {
+	// [2]+[3] 解析AST并提取出每个syzlang文件用到的常量
+	infos, err := extractArchConsts(arch)
+	if err != nil {
+		return nil, err
+	}
+	// [4] 补全某些arch的kern src可能会缺失的头文件
+	if err := extractor.prepareArch(arch); err != nil {
+		return nil, err
+	}
+	// [4.5] 计算头文件指纹，供常量缓存使用
+	hash, err := headerHash(arch)
+	if err != nil {
+		return nil, err
+	}
+	arch.hdrHash = hash
+	return infos, nil // [5] 将获取到的consts infos 返回给调用者
+}
+
+// extractArchConsts parses the syzlang AST for OS and runs compiler.ExtractConsts
+// for arch, without touching the Extractor (no prepareArch/processFile calls).
+// It is shared by the normal extraction path and -plan.
+func extractArchConsts(arch *Arch) (map[string]*compiler.ConstInfo, error) {
 	errBuf := new(bytes.Buffer)
 	eh := func(pos ast.Pos, msg string) { // [1] 定义错误处理函数
 		fmt.Fprintf(errBuf, "%v: %v\n", pos, msg)
 	}
-	// [2] 将编写的txt文件解析成AST
-	// top变量就是ast森林的根节点
+	// 将编写的txt文件解析成AST，top变量就是ast森林的根节点
 	top := ast.ParseGlob(filepath.Join("sys", arch.target.OS, "*.txt"), eh)
 	if top == nil {
 		return nil, fmt.Errorf("%v", errBuf.String())
 	}
-	// [3] 从每个syzlang文件中提取出const值，返回syzlang文件名与其用到的常量数组的映射
+	// 从每个syzlang文件中提取出const值，返回syzlang文件名与其用到的常量数组的映射
 	infos := compiler.ExtractConsts(top, arch.target, eh)
 	if infos == nil {
 		return nil, fmt.Errorf("%v", errBuf.String())
 	}
-	// [4] 补全某些arch的kern src可能会缺失的头文件
-	if err := extractor.prepareArch(arch); err != nil {
-		return nil, err
+	return infos, nil
+}
+
+func processFile(ctx context.Context, extractor Extractor, arch *Arch, file *File) (map[string]uint64, map[string]bool, error) {
+	start := time.Now()
+	emitEvent(event{Event: "start", OS: arch.target.OS, Arch: arch.target.Arch, File: file.name})
+	consts, undeclared, err := processFileInner(ctx, extractor, arch, file)
+	elapsed := time.Since(start).Milliseconds()
+	if err != nil {
+		emitEvent(event{Event: "error", OS: arch.target.OS, Arch: arch.target.Arch, File: file.name, ElapsedMS: elapsed, Err: err.Error()})
+	} else {
+		emitEvent(event{
+			Event: "done", OS: arch.target.OS, Arch: arch.target.Arch, File: file.name, ElapsedMS: elapsed,
+			NumConsts: len(consts), NumUndeclared: len(undeclared),
+		})
 	}
-	return infos, nil // [5] 将获取到的consts infos 返回给调用者
+	return consts, undeclared, err
 }
 
-func processFile(extractor Extractor, arch *Arch, file *File) (map[string]uint64, map[string]bool, error) {
+func processFileInner(ctx context.Context, extractor Extractor, arch *Arch, file *File) (map[string]uint64, map[string]bool, error) {
 	inname := filepath.Join("sys", arch.target.OS, file.name)
 	if file.info == nil {
 		return nil, nil, fmt.Errorf("const info for input file %v is missing", inname)
@@ -303,5 +417,61 @@ func processFile(extractor Extractor, arch *Arch, file *File) (map[string]uint64
 	if len(file.info.Consts) == 0 {
 		return nil, nil, nil
 	}
-	return extractor.processFile(arch, file.info)
+	consts, undeclared, miss := lookupCached(arch, file)
+	if len(miss) == 0 {
+		return consts, undeclared, nil
+	}
+	missInfo := *file.info
+	missInfo.Consts = miss
+	newConsts, newUndeclared, err := extractor.processFile(ctx, arch, &missInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	storeCached(arch, file.name, miss, newConsts, newUndeclared)
+	for name, val := range newConsts {
+		consts[name] = val
+	}
+	for name := range newUndeclared {
+		undeclared[name] = true
+	}
+	return consts, undeclared, nil
+}
+
+// lookupCached splits file.info.Consts into cache hits (returned directly)
+// and misses (to be passed down to the extractor).
+func lookupCached(arch *Arch, file *File) (consts map[string]uint64, undeclared map[string]bool, miss []string) {
+	consts = make(map[string]uint64)
+	undeclared = make(map[string]bool)
+	for _, name := range file.info.Consts {
+		key := extractCache.key(arch.target.OS, arch.target.Arch, file.name, name, arch.hdrHash)
+		entry, ok := extractCache.lookup(key)
+		if !ok {
+			miss = append(miss, name)
+			continue
+		}
+		if entry.Undeclared {
+			undeclared[name] = true
+		} else {
+			consts[name] = entry.Value
+		}
+	}
+	return consts, undeclared, miss
+}
+
+func storeCached(arch *Arch, file string, consts []string, values map[string]uint64, undeclared map[string]bool) {
+	for _, name := range consts {
+		key := extractCache.key(arch.target.OS, arch.target.Arch, file, name, arch.hdrHash)
+		entry := cacheEntry{Undeclared: undeclared[name]}
+		if val, ok := values[name]; ok {
+			entry.Value = val
+		}
+		if err := extractCache.store(key, entry); err != nil {
+			msg := fmt.Sprintf("failed to write cache entry for %v: %v", name, err)
+			if *flagJSON {
+				emitEvent(event{Event: "error", OS: arch.target.OS, Arch: arch.target.Arch, File: file, Err: msg})
+			} else {
+				fmt.Printf("%v: %v\n", file, msg)
+			}
+		}
+	}
 }
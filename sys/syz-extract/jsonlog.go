@@ -0,0 +1,38 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// event is one line of the -json output stream, describing progress of a
+// single arch or file job so that CI/dashboards can consume extraction
+// results without scraping free-form text.
+type event struct {
+	Event         string `json:"event"` // "start", "done" or "error"
+	OS            string `json:"os"`
+	Arch          string `json:"arch,omitempty"`
+	File          string `json:"file,omitempty"`
+	ElapsedMS     int64  `json:"elapsed_ms,omitempty"`
+	NumConsts     int    `json:"num_consts,omitempty"`
+	NumUndeclared int    `json:"num_undeclared,omitempty"`
+	Err           string `json:"err,omitempty"`
+}
+
+var (
+	jsonMu  sync.Mutex
+	jsonEnc = json.NewEncoder(os.Stdout)
+)
+
+func emitEvent(ev event) {
+	if !*flagJSON {
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	jsonEnc.Encode(ev)
+}
@@ -0,0 +1,91 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/google/syzkaller/pkg/compiler"
+)
+
+type linux struct{}
+
+func (*linux) prepare(sourcedir string, build bool, arches []*Arch) error {
+	if sourcedir == "" {
+		return fmt.Errorf("provide path to kernel checkout via -sourcedir flag (or make extract SOURCEDIR)")
+	}
+	return nil
+}
+
+var linuxHeaderArches = map[string]string{
+	"386":      "x86",
+	"amd64":    "x86",
+	"arm":      "arm",
+	"arm64":    "arm64",
+	"mips64le": "mips",
+	"ppc64le":  "powerpc",
+	"riscv64":  "riscv",
+	"s390x":    "s390",
+}
+
+func linuxHeaderArch(arch string) string {
+	if a, ok := linuxHeaderArches[arch]; ok {
+		return a
+	}
+	return arch
+}
+
+// prepareArch runs `make defconfig && make prepare` in a scratch build dir so
+// that arch-specific generated headers (e.g. asm/unistd.h) exist before we
+// compile probe programs against them.
+func (*linux) prepareArch(arch *Arch) error {
+	if !arch.build {
+		return nil
+	}
+	headerArch := linuxHeaderArch(arch.target.Arch)
+	cmd := exec.Command("make", "-C", arch.sourceDir,
+		"O="+arch.buildDir, "ARCH="+headerArch, "defconfig")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("make defconfig failed: %v\n%s", err, out)
+	}
+	cmd = exec.Command("make", "-C", arch.sourceDir,
+		"O="+arch.buildDir, "ARCH="+headerArch, "prepare")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("make prepare failed: %v\n%s", err, out)
+	}
+	return nil
+}
+
+func (*linux) processFile(ctx context.Context, arch *Arch, info *compiler.ConstInfo) (map[string]uint64, map[string]bool, error) {
+	cc := &ccompiler{
+		target:     arch.target,
+		buildDir:   arch.buildDir,
+		includeDir: arch.includeDirs,
+	}
+	probe, err := genProbe(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(probe)
+	cflags := []string{
+		"-I", filepath.Join(arch.sourceDir, "include"),
+		"-I", filepath.Join(arch.sourceDir, "arch", linuxHeaderArch(arch.target.Arch), "include"),
+	}
+	if arch.build {
+		headerArch := linuxHeaderArch(arch.target.Arch)
+		cflags = append(cflags,
+			"-I", filepath.Join(arch.buildDir, "arch", headerArch, "include", "generated"),
+			"-I", filepath.Join(arch.buildDir, "arch", headerArch, "include", "generated", "uapi"),
+		)
+	}
+	out, err := cc.compileAndRun(ctx, probe, cflags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parseProbeOutput(out)
+}
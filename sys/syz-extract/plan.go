@@ -0,0 +1,96 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// planEvent is the -json counterpart of the plain-text -plan output below.
+type planEvent struct {
+	Event    string   `json:"event"`
+	OS       string   `json:"os"`
+	Arch     string   `json:"arch"`
+	File     string   `json:"file"`
+	Consts   []string `json:"consts"`
+	Includes []string `json:"includes"`
+	Cached   int      `json:"cached"`
+}
+
+func emitPlanEvent(ev planEvent) {
+	if !*flagJSON {
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	jsonEnc.Encode(ev)
+}
+
+func reportPlanErr(OS, arch string, err error) {
+	if *flagJSON {
+		emitEvent(event{Event: "error", OS: OS, Arch: arch, Err: err.Error()})
+		return
+	}
+	fmt.Printf("%v/%v: %v\n", OS, arch, err)
+}
+
+// runPlan prints, for every (arch, file) pair, the constants that would be
+// resolved and the include dirs/headers that would be pulled in, along with
+// how many of those constants are already cached. It only parses the
+// syzlang AST and runs compiler.ExtractConsts -- it never calls
+// extractor.prepare, prepareArch or processFile, so it's safe to run before
+// committing to a multi-hour cross-arch build.
+func runPlan(OS string, arches []*Arch) bool {
+	failed := false
+	for _, arch := range arches {
+		infos, err := extractArchConsts(arch)
+		if err != nil {
+			failed = true
+			reportPlanErr(OS, arch.target.Arch, err)
+			continue
+		}
+		// headerHash doesn't require prepareArch to have run; for -build
+		// arches this is only an approximation since generated headers
+		// (e.g. asm/unistd.h) don't exist yet.
+		hash, err := headerHash(arch)
+		if err != nil {
+			failed = true
+			reportPlanErr(OS, arch.target.Arch, err)
+			continue
+		}
+		for _, f := range arch.files {
+			info := infos[filepath.Join("sys", OS, f.name)]
+			if info == nil || len(info.Consts) == 0 {
+				continue
+			}
+			cached := 0
+			for _, name := range info.Consts {
+				key := extractCache.key(OS, arch.target.Arch, f.name, name, hash)
+				if _, ok := extractCache.lookup(key); ok {
+					cached++
+				}
+			}
+			includes := append([]string{}, info.Includes...)
+			for _, dir := range strings.Split(arch.includeDirs, ",") {
+				if dir != "" {
+					includes = append(includes, dir)
+				}
+			}
+			if *flagJSON {
+				emitPlanEvent(planEvent{
+					Event: "plan", OS: OS, Arch: arch.target.Arch, File: f.name,
+					Consts: info.Consts, Includes: includes, Cached: cached,
+				})
+				continue
+			}
+			fmt.Printf("%v/%v: %v: %v consts (%v cached)\n",
+				OS, arch.target.Arch, f.name, len(info.Consts), cached)
+			fmt.Printf("  consts: %v\n", strings.Join(info.Consts, ", "))
+			fmt.Printf("  includes: %v\n", strings.Join(includes, ", "))
+		}
+	}
+	return failed
+}
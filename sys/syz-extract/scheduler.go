@@ -0,0 +1,63 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// Default memory budgets used when dispatching a job, per the -memlimit
+// scheduler below. A -build arch job compiles kernel headers (heavyweight),
+// while a plain file job only compiles and runs a small probe program.
+const (
+	archBuildMemBudget = 2 << 30  // 2 GiB
+	archPlainMemBudget = 64 << 20 // 64 MiB
+	fileMemBudget      = 256 << 20
+)
+
+func archMemBudget(arch *Arch) int64 {
+	if arch.build {
+		return archBuildMemBudget
+	}
+	return archPlainMemBudget
+}
+
+// memSem is a weighted semaphore: it bounds the total estimated memory of
+// in-flight jobs rather than just their count, so that e.g. a handful of
+// -build arch jobs (each compiling kernel headers) don't get scheduled
+// concurrently and OOM the machine. A request larger than the whole limit is
+// still allowed to run alone once nothing else is in flight, so a single
+// oversized job can't deadlock the scheduler.
+type memSem struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemSem(limit int64) *memSem {
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	s := &memSem{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *memSem) acquire(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.used > 0 && s.used+n > s.limit {
+		s.cond.Wait()
+	}
+	s.used += n
+}
+
+func (s *memSem) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.used -= n
+	s.cond.Broadcast()
+}
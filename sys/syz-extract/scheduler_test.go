@@ -0,0 +1,108 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArchMemBudget(t *testing.T) {
+	if got := archMemBudget(&Arch{build: true}); got != archBuildMemBudget {
+		t.Fatalf("archMemBudget(build=true) = %v, want %v", got, archBuildMemBudget)
+	}
+	if got := archMemBudget(&Arch{build: false}); got != archPlainMemBudget {
+		t.Fatalf("archMemBudget(build=false) = %v, want %v", got, archPlainMemBudget)
+	}
+}
+
+func TestMemSemAcquireRelease(t *testing.T) {
+	s := newMemSem(100)
+	s.acquire(60)
+	s.acquire(40)
+	done := make(chan bool)
+	go func() {
+		s.acquire(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatalf("acquire() did not block once the limit was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.release(40)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire() did not unblock after release()")
+	}
+	s.release(61)
+}
+
+func TestMemSemOversizedRequestRunsAlone(t *testing.T) {
+	// A request larger than the whole limit must still be allowed to run
+	// once nothing else is in flight, or it would deadlock the scheduler
+	// forever.
+	s := newMemSem(10)
+	done := make(chan bool)
+	go func() {
+		s.acquire(1000)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire() of an oversized request deadlocked with nothing else in flight")
+	}
+	s.release(1000)
+}
+
+func TestMemSemUnlimited(t *testing.T) {
+	s := newMemSem(0)
+	done := make(chan bool)
+	go func() {
+		s.acquire(1 << 62)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("acquire() blocked despite limit<=0 meaning unlimited")
+	}
+}
+
+func TestMemSemConcurrentNeverExceedsLimit(t *testing.T) {
+	const limit = int64(50)
+	const workers = 20
+	const n = int64(7)
+	s := newMemSem(limit)
+	var mu sync.Mutex
+	var cur, max int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				s.acquire(n)
+				mu.Lock()
+				cur += n
+				if cur > max {
+					max = cur
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				cur -= n
+				mu.Unlock()
+				s.release(n)
+			}
+		}()
+	}
+	wg.Wait()
+	if max > limit && max > n {
+		t.Fatalf("observed in-flight usage %v exceeded limit %v (beyond the single-oversized-request allowance)", max, limit)
+	}
+}
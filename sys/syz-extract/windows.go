@@ -0,0 +1,64 @@
+// Copyright 2016 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/google/syzkaller/pkg/compiler"
+)
+
+type windows struct{}
+
+func (*windows) prepare(sourcedir string, build bool, arches []*Arch) error {
+	if sourcedir == "" {
+		return fmt.Errorf("provide path to Windows Driver Kit headers via -sourcedir flag (or make extract SOURCEDIR)")
+	}
+	if build {
+		return fmt.Errorf("-build is not supported for windows")
+	}
+	return nil
+}
+
+func (*windows) prepareArch(arch *Arch) error {
+	return nil
+}
+
+// processFile cross-compiles the probe with the mingw toolchain for arch and
+// runs the resulting PE binary under Wine, since the host can never natively
+// execute it.
+func (*windows) processFile(ctx context.Context, arch *Arch, info *compiler.ConstInfo) (map[string]uint64, map[string]bool, error) {
+	probe, err := genProbe(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.Remove(probe)
+	bin := probe + ".exe"
+	defer os.Remove(bin)
+
+	args := []string{"-x", "c", "-o", bin, probe, "-w"}
+	for _, dir := range strings.Split(arch.includeDirs, ",") {
+		if dir == "" {
+			continue
+		}
+		args = append(args, "-I"+dir)
+	}
+	args = append(args, "-I"+arch.sourceDir)
+	args = append(args, arch.target.CrossCFlags...)
+	compiler := arch.target.CCompilerPrefix + "gcc"
+	cmd := exec.CommandContext(ctx, compiler, args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("failed to compile probe: %v\n%s", err, out)
+	}
+
+	out, err := exec.CommandContext(ctx, "wine", bin).CombinedOutput()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to run probe under wine: %v\n%s", err, out)
+	}
+	return parseProbeOutput(string(out))
+}